@@ -0,0 +1,214 @@
+package squash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// errEmptyLayerRange is returned by chainIDForRange when start == end: there
+// are no layers above the squash point to merge, so there is no meaningful
+// ChainID to cache under. Callers should skip caching for this squash
+// rather than using a blank ChainID, which would alias every such no-op
+// squash onto the same cache file.
+var errEmptyLayerRange = errors.New("squash: no layers to merge in this range")
+
+// defaultCacheMaxBytes bounds the total size of cached layer tars before
+// the LRU eviction kicks in, unless a Squasher overrides it.
+const defaultCacheMaxBytes int64 = 10 << 30 // 10GiB
+
+// chainIDForRange computes the ChainID of the layers strictly above start
+// up to and including end, the same way a content-addressable image store
+// chains layer diffIDs: chainID(layer0) = diffID0, chainID(n) =
+// sha256(chainID(n-1) + " " + diffIDn). start itself is excluded because it
+// is preserved as-is, not part of the range being merged.
+func chainIDForRange(start *Layer, end *Layer) (string, error) {
+	var layers []*Layer
+	for l := end; l != nil && l.LayerConfig.Id != start.LayerConfig.Id; l = l.Parent() {
+		layers = append(layers, l)
+	}
+	// layers is currently child->parent; reverse it to parent->child so the
+	// chain is computed in the same order SquashLayers merges them.
+	for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+		layers[i], layers[j] = layers[j], layers[i]
+	}
+
+	if len(layers) == 0 {
+		return "", errEmptyLayerRange
+	}
+
+	var chainID string
+	for _, l := range layers {
+		diffID, err := diffIDOf(l.LayerTarPath())
+		if err != nil {
+			return "", err
+		}
+		if chainID == "" {
+			chainID = diffID
+			continue
+		}
+		sum := sha256.Sum256([]byte(chainID + " " + diffID))
+		chainID = hex.EncodeToString(sum[:])
+	}
+	return chainID, nil
+}
+
+// diffIDOf returns the sha256 of the uncompressed layer tar at path.
+func diffIDOf(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LayerCache stores squashed layer.tar files under dir, keyed by ChainID, so
+// a later squash over the same input layers can reuse the result instead of
+// re-extracting and re-merging them. Entries are evicted oldest-access-first
+// once the cache grows past MaxBytes.
+type LayerCache struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// NewLayerCache returns a LayerCache rooted at dir with the default size
+// limit.
+func NewLayerCache(dir string) *LayerCache {
+	return &LayerCache{Dir: dir, MaxBytes: defaultCacheMaxBytes}
+}
+
+func (c *LayerCache) path(chainID string) string {
+	return filepath.Join(c.Dir, chainID+".tar")
+}
+
+// CopyInto copies the cached tar for chainID into dest, if present, touching
+// its access time for LRU purposes. It reports whether a cache entry was
+// found.
+func (c *LayerCache) CopyInto(chainID string, dest string) (bool, error) {
+	src := c.path(chainID)
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	return true, os.Chtimes(src, now, now)
+}
+
+// Put stores the tar at srcPath into the cache under chainID, then evicts
+// the least-recently-used entries until the cache fits within MaxBytes.
+func (c *LayerCache) Put(chainID string, srcPath string) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(c.Dir, "layer-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, c.path(chainID)); err != nil {
+		return err
+	}
+
+	return c.evict()
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+// evict removes the oldest-accessed cache entries until the total cached
+// size is within MaxBytes.
+func (c *LayerCache) evict() error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	var items []cacheEntry
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		items = append(items, cacheEntry{
+			path:    filepath.Join(c.Dir, e.Name()),
+			size:    e.Size(),
+			modTime: e.ModTime().UnixNano(),
+		})
+		total += e.Size()
+	}
+
+	if total <= c.maxBytes() {
+		return nil
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].modTime < items[j].modTime })
+
+	for _, item := range items {
+		if total <= c.maxBytes() {
+			break
+		}
+		if err := os.Remove(item.path); err != nil {
+			return err
+		}
+		total -= item.size
+	}
+
+	return nil
+}
+
+func (c *LayerCache) maxBytes() int64 {
+	if c.MaxBytes <= 0 {
+		return defaultCacheMaxBytes
+	}
+	return c.MaxBytes
+}
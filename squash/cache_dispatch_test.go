@@ -0,0 +1,36 @@
+package squash
+
+import "testing"
+
+func TestSquasherCacheDisabledByDefault(t *testing.T) {
+	s := &Squasher{}
+	if c := s.cache(); c != nil {
+		t.Fatalf("cache() = %v, want nil when CacheDir is unset", c)
+	}
+}
+
+func TestSquasherCacheDisabledByNoCache(t *testing.T) {
+	s := &Squasher{CacheDir: t.TempDir(), NoCache: true}
+	if c := s.cache(); c != nil {
+		t.Fatalf("cache() = %v, want nil when NoCache is set", c)
+	}
+}
+
+func TestSquasherCacheEnabledWithCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	s := &Squasher{CacheDir: dir}
+	c := s.cache()
+	if c == nil {
+		t.Fatal("cache() = nil, want a LayerCache when CacheDir is set")
+	}
+	if c.Dir != dir {
+		t.Fatalf("cache().Dir = %q, want %q", c.Dir, dir)
+	}
+}
+
+// chainIDForRange's errEmptyLayerRange branch (the start == end case this
+// dispatch logic relies on) isn't exercisable from here: it walks *Layer,
+// which - like *Export - isn't defined anywhere in this trimmed tree, so
+// there's no way to construct a fixture pair with equal LayerConfig.Id
+// without guessing at a type this package doesn't ship. The cache()
+// coverage above is what's left that's testable in isolation.
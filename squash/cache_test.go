@@ -0,0 +1,123 @@
+package squash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiffIDOf(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "layer.tar")
+	content := []byte("fake uncompressed tar content")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := diffIDOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:])
+	if got != want {
+		t.Fatalf("diffIDOf = %s, want %s", got, want)
+	}
+}
+
+func TestLayerCachePutAndCopyInto(t *testing.T) {
+	cacheDir := t.TempDir()
+	cache := NewLayerCache(cacheDir)
+
+	srcDir := t.TempDir()
+	srcPath := filepath.Join(srcDir, "layer.tar")
+	if err := ioutil.WriteFile(srcPath, []byte("squashed layer contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Put("deadbeef", srcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	destPath := filepath.Join(destDir, "layer.tar")
+
+	hit, err := cache.CopyInto("deadbeef", destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hit {
+		t.Fatal("expected a cache hit after Put")
+	}
+
+	got, err := ioutil.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "squashed layer contents" {
+		t.Fatalf("copied content = %q", got)
+	}
+
+	missDest := filepath.Join(destDir, "miss.tar")
+	hit, err = cache.CopyInto("not-cached", missDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit {
+		t.Fatal("expected a cache miss for an unknown ChainID")
+	}
+}
+
+func TestLayerCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cacheDir := t.TempDir()
+	cache := &LayerCache{Dir: cacheDir, MaxBytes: 10}
+
+	srcDir := t.TempDir()
+	put := func(chainID string, content string) {
+		p := filepath.Join(srcDir, chainID)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Put(chainID, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("old", "01234")
+	// Force a distinct mtime ordering between entries on coarse filesystem
+	// clocks.
+	time.Sleep(10 * time.Millisecond)
+	put("new", "56789")
+
+	if total := cacheSize(t, cacheDir); total > 10 {
+		t.Fatalf("cache size %d exceeds MaxBytes before eviction trigger", total)
+	}
+
+	// Pushing past MaxBytes should evict "old" (least recently used) first.
+	put("newest", "abcde")
+
+	if _, err := os.Stat(filepath.Join(cacheDir, "old.tar")); !os.IsNotExist(err) {
+		t.Fatalf("expected least-recently-used entry to be evicted, stat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "newest.tar")); err != nil {
+		t.Fatalf("expected newest entry to survive eviction: %v", err)
+	}
+}
+
+func cacheSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+	return total
+}
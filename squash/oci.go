@@ -0,0 +1,209 @@
+package squash
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Output format names accepted by Squasher.Format. FormatDockerV1 is the
+// legacy single-tarball layout this package has always produced;
+// FormatOCI writes an OCI image layout (index.json, oci-layout, blobs/)
+// that containerd, podman, and skopeo can consume directly.
+const (
+	FormatDockerV1 = "docker-v1"
+	FormatOCI      = "oci"
+)
+
+const ociLayoutVersion = "1.0.0"
+
+// ociDescriptor mirrors an OCI content descriptor: media type, digest and
+// size of a blob.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// ociManifest mirrors application/vnd.oci.image.manifest.v1+json.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociRootFS mirrors the rootfs section of an OCI image config.
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// ociImageConfig mirrors the subset of the OCI image config we populate for
+// a squashed image: the creation time and one rootfs diffID per layer of the
+// output chain, with no history entries.
+type ociImageConfig struct {
+	Created time.Time `json:"created"`
+	RootFS  ociRootFS `json:"rootfs"`
+}
+
+// writeOCILayout writes layers (the full preserved chain from the image
+// root down to the freshly squashed layer, in parent->child order) out as
+// an OCI image layout rooted at dir: oci-layout, index.json and
+// blobs/sha256/<digest> for the config and every layer's gzipped blob.
+//
+// A partial squash (parent != "") keeps everything below parent untouched,
+// so the OCI output has to carry all of those layers too, not just the new
+// one - otherwise its rootfs.diff_ids would describe a filesystem missing
+// its base.
+//
+// Each layer's diffID is the sha256 of its uncompressed tar; its digest is
+// the sha256 of the gzip-compressed blob actually stored under blobs/. Both
+// are required to build a config whose rootfs.diff_ids moby/containerd can
+// resolve back to a ChainID.
+func writeOCILayout(dir string, layers []*Layer) error {
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return err
+	}
+
+	diffIDs := make([]string, 0, len(layers))
+	layerDescriptors := make([]ociDescriptor, 0, len(layers))
+	for _, layer := range layers {
+		diffID, gzipDigest, gzipSize, err := writeGzippedLayerBlob(blobDir, layer.LayerTarPath())
+		if err != nil {
+			return err
+		}
+		diffIDs = append(diffIDs, "sha256:"+diffID)
+		layerDescriptors = append(layerDescriptors, ociDescriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    "sha256:" + gzipDigest,
+			Size:      gzipSize,
+		})
+	}
+
+	config := ociImageConfig{
+		Created: time.Now().UTC(),
+		RootFS: ociRootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	}
+	configDigest, configSize, err := writeJSONBlob(blobDir, config)
+	if err != nil {
+		return err
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: ociDescriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    "sha256:" + configDigest,
+			Size:      configSize,
+		},
+		Layers: layerDescriptors,
+	}
+	manifestDigest, manifestSize, err := writeJSONBlob(blobDir, manifest)
+	if err != nil {
+		return err
+	}
+
+	index := struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		Manifests     []ociDescriptor `json:"manifests"`
+	}{
+		SchemaVersion: 2,
+		Manifests: []ociDescriptor{
+			{
+				MediaType: "application/vnd.oci.image.manifest.v1+json",
+				Digest:    "sha256:" + manifestDigest,
+				Size:      manifestSize,
+			},
+		},
+	}
+	if err := writeJSONFile(filepath.Join(dir, "index.json"), index); err != nil {
+		return err
+	}
+
+	layoutFile := struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}{ImageLayoutVersion: ociLayoutVersion}
+	return writeJSONFile(filepath.Join(dir, "oci-layout"), layoutFile)
+}
+
+// writeGzippedLayerBlob gzips the tar at tarPath into blobDir/<digest>
+// and returns the uncompressed tar's diffID alongside the gzipped blob's own
+// digest and size.
+func writeGzippedLayerBlob(blobDir string, tarPath string) (diffID string, digest string, size int64, err error) {
+	in, err := os.Open(tarPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer in.Close()
+
+	diffSum := sha256.New()
+	tmp, err := ioutil.TempFile(blobDir, "layer-")
+	if err != nil {
+		return "", "", 0, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	gzipSum := sha256.New()
+	gw := gzip.NewWriter(io.MultiWriter(tmp, gzipSum))
+
+	if _, err := io.Copy(io.MultiWriter(gw, diffSum), in); err != nil {
+		return "", "", 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return "", "", 0, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", "", 0, err
+	}
+	size = info.Size()
+	diffID = hex.EncodeToString(diffSum.Sum(nil))
+	digest = hex.EncodeToString(gzipSum.Sum(nil))
+
+	if err := tmp.Close(); err != nil {
+		return "", "", 0, err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(blobDir, digest)); err != nil {
+		return "", "", 0, err
+	}
+
+	return diffID, digest, size, nil
+}
+
+// writeJSONBlob marshals v, writes it to blobDir/<digest> and returns
+// that digest and size.
+func writeJSONBlob(blobDir string, v interface{}) (digest string, size int64, err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(b)
+	digest = hex.EncodeToString(sum[:])
+	if err := ioutil.WriteFile(filepath.Join(blobDir, digest), b, 0644); err != nil {
+		return "", 0, err
+	}
+	return digest, int64(len(b)), nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,125 @@
+package squash
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteJSONBlob(t *testing.T) {
+	dir := t.TempDir()
+
+	v := struct {
+		Foo string `json:"foo"`
+	}{Foo: "bar"}
+
+	digest, size, err := writeJSONBlob(dir, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	wantDigest := hex.EncodeToString(sum[:])
+
+	if digest != wantDigest {
+		t.Fatalf("digest = %s, want %s", digest, wantDigest)
+	}
+	if size != int64(len(b)) {
+		t.Fatalf("size = %d, want %d", size, len(b))
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(b) {
+		t.Fatalf("blob contents = %q, want %q", got, b)
+	}
+}
+
+func TestWriteGzippedLayerBlob(t *testing.T) {
+	srcDir := t.TempDir()
+	tarPath := filepath.Join(srcDir, "layer.tar")
+	content := []byte("pretend this is a tar stream")
+	if err := ioutil.WriteFile(tarPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	blobDir := t.TempDir()
+	diffID, digest, size, err := writeGzippedLayerBlob(blobDir, tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDiffSum := sha256.Sum256(content)
+	if diffID != hex.EncodeToString(wantDiffSum[:]) {
+		t.Fatalf("diffID = %s, want sha256 of uncompressed content", diffID)
+	}
+
+	blobPath := filepath.Join(blobDir, digest)
+	f, err := os.Open(blobPath)
+	if err != nil {
+		t.Fatalf("expected blob to be written to %s: %v", blobPath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Fatalf("reported size %d does not match blob file size %d", size, info.Size())
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("blob is not valid gzip: %v", err)
+	}
+	defer gz.Close()
+
+	got, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("decompressed blob = %q, want %q", got, content)
+	}
+}
+
+func TestWriteJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "oci-layout")
+
+	v := struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}{ImageLayoutVersion: ociLayoutVersion}
+
+	if err := writeJSONFile(path, v); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}
+	if err := json.NewDecoder(f).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ImageLayoutVersion != ociLayoutVersion {
+		t.Fatalf("imageLayoutVersion = %q, want %q", got.ImageLayoutVersion, ociLayoutVersion)
+	}
+}
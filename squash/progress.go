@@ -0,0 +1,130 @@
+package squash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Progress is how Squash reports byte-level progress on its long-running
+// steps (extracting layers, merging them, tarring the result) instead of
+// just logging debug strings. Implementations must be safe for concurrent
+// use: ExtractLayers and TarLayers report on multiple layers in parallel
+// via the TransferManager worker pool.
+type Progress interface {
+	// Start announces a new unit of work identified by id, with a
+	// human-readable description and a total size (bytes, or -1 if unknown).
+	Start(id, description string, total int64)
+	// Update reports that id has progressed to current out of the total
+	// given to Start.
+	Update(id string, current int64)
+	// Complete marks id as finished.
+	Complete(id string)
+}
+
+// NoopProgress discards all progress events. It's the default Progress on
+// a zero-value Squasher.
+type NoopProgress struct{}
+
+func (NoopProgress) Start(id, description string, total int64) {}
+func (NoopProgress) Update(id string, current int64)           {}
+func (NoopProgress) Complete(id string)                        {}
+
+// progress returns s.Progress, defaulting to NoopProgress so callers never
+// need a nil check.
+func (s *Squasher) progress() Progress {
+	if s.Progress == nil {
+		return NoopProgress{}
+	}
+	return s.Progress
+}
+
+// TerminalProgress renders each tracked id as a line of a simple byte-count
+// progress bar, suitable for an interactive terminal.
+type TerminalProgress struct {
+	Out io.Writer
+
+	mu     sync.Mutex
+	order  []string
+	totals map[string]int64
+}
+
+func NewTerminalProgress(out io.Writer) *TerminalProgress {
+	return &TerminalProgress{Out: out, totals: map[string]int64{}}
+}
+
+func (t *TerminalProgress) Start(id, description string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.order = append(t.order, id)
+	t.totals[id] = total
+	fmt.Fprintf(t.Out, "%s: %s\n", id, description)
+}
+
+func (t *TerminalProgress) Update(id string, current int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := t.totals[id]
+	if total > 0 {
+		fmt.Fprintf(t.Out, "\r%s: %d/%d bytes", id, current, total)
+	} else {
+		fmt.Fprintf(t.Out, "\r%s: %d bytes", id, current)
+	}
+}
+
+func (t *TerminalProgress) Complete(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.Out, "\r%s: done%s\n", id, strings.Repeat(" ", 20))
+}
+
+// jsonProgressMessage mirrors the fields of Docker's
+// jsonmessage.JSONMessage that existing Docker UIs already know how to
+// render, so JSONProgress output can be piped straight into them.
+type jsonProgressMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id,omitempty"`
+	Progress       string `json:"progress,omitempty"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail,omitempty"`
+}
+
+// JSONProgress emits one jsonProgressMessage per event as a line of JSON,
+// for CI pipelines and orchestrators that want machine-readable progress.
+type JSONProgress struct {
+	Out io.Writer
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONProgress(out io.Writer) *JSONProgress {
+	return &JSONProgress{Out: out, enc: json.NewEncoder(out)}
+}
+
+func (j *JSONProgress) emit(msg jsonProgressMessage) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// Best-effort: a broken progress sink shouldn't fail the squash itself.
+	_ = j.enc.Encode(msg)
+}
+
+func (j *JSONProgress) Start(id, description string, total int64) {
+	msg := jsonProgressMessage{Status: description, ID: id}
+	msg.ProgressDetail.Total = total
+	j.emit(msg)
+}
+
+func (j *JSONProgress) Update(id string, current int64) {
+	msg := jsonProgressMessage{Status: "Progress", ID: id}
+	msg.ProgressDetail.Current = current
+	j.emit(msg)
+}
+
+func (j *JSONProgress) Complete(id string) {
+	j.emit(jsonProgressMessage{Status: "Complete", ID: id})
+}
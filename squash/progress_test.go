@@ -0,0 +1,74 @@
+package squash
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNoopProgressDoesNothing(t *testing.T) {
+	var p Progress = NoopProgress{}
+	// These just need to not panic; NoopProgress has no observable state.
+	p.Start("id", "desc", 100)
+	p.Update("id", 50)
+	p.Complete("id")
+}
+
+func TestTerminalProgressReportsDescriptionAndBytes(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewTerminalProgress(&buf)
+
+	p.Start("layer1", "Extracting layer1", 100)
+	p.Update("layer1", 50)
+	p.Complete("layer1")
+
+	out := buf.String()
+	if !strings.Contains(out, "Extracting layer1") {
+		t.Fatalf("expected Start's description in output, got %q", out)
+	}
+	if !strings.Contains(out, "50/100 bytes") {
+		t.Fatalf("expected Update's current/total in output, got %q", out)
+	}
+	if !strings.Contains(out, "layer1: done") {
+		t.Fatalf("expected Complete to report done, got %q", out)
+	}
+}
+
+func TestJSONProgressEmitsOneLinePerEvent(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewJSONProgress(&buf)
+
+	p.Start("layer1", "Extracting layer1", 100)
+	p.Update("layer1", 50)
+	p.Complete("layer1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 JSON lines, got %d: %q", len(lines), buf.String())
+	}
+
+	var start jsonProgressMessage
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil {
+		t.Fatalf("Start line is not valid JSON: %v", err)
+	}
+	if start.ID != "layer1" || start.Status != "Extracting layer1" || start.ProgressDetail.Total != 100 {
+		t.Fatalf("unexpected Start message: %+v", start)
+	}
+
+	var update jsonProgressMessage
+	if err := json.Unmarshal([]byte(lines[1]), &update); err != nil {
+		t.Fatalf("Update line is not valid JSON: %v", err)
+	}
+	if update.ProgressDetail.Current != 50 {
+		t.Fatalf("unexpected Update message: %+v", update)
+	}
+
+	var complete jsonProgressMessage
+	if err := json.Unmarshal([]byte(lines[2]), &complete); err != nil {
+		t.Fatalf("Complete line is not valid JSON: %v", err)
+	}
+	if complete.Status != "Complete" {
+		t.Fatalf("unexpected Complete message: %+v", complete)
+	}
+}
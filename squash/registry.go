@@ -0,0 +1,314 @@
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloud66/cxbuild/configuration"
+	"github.com/cloud66/cxbuild/registry"
+)
+
+// dockerRefPrefix marks an input/output as a registry reference rather than
+// a local tar path, e.g. "docker://registry.example.com/foo/bar:latest".
+const dockerRefPrefix = "docker://"
+
+// isRegistryRef reports whether ref is a docker:// registry reference as
+// opposed to a local file path or "-"/"" for stdio.
+func isRegistryRef(ref string) bool {
+	return strings.HasPrefix(ref, dockerRefPrefix)
+}
+
+// ImageSource is anything Squash can read a manifest and layer blobs from.
+// LoadExport's local-tar reader and registryImageSource both satisfy it.
+type ImageSource interface {
+	// Manifest returns the parsed manifest for the image (schema2 or OCI).
+	Manifest() (*registry.Manifest, error)
+	// Blob streams the layer blob for the given digest.
+	Blob(digest string) (io.ReadCloser, error)
+}
+
+// ImageDestination is anything Squash can write a squashed image's manifest
+// and layer blob to. The local tar writer and registryImageDestination both
+// satisfy it.
+type ImageDestination interface {
+	// PutBlob uploads a layer or config blob of the given digest and size.
+	PutBlob(digest string, size int64, r io.Reader) error
+	// PutManifest uploads the image's manifest, completing the push.
+	PutManifest(m *registry.Manifest) error
+}
+
+// newImageSource returns a registry-backed ImageSource for a docker:// ref,
+// pulling the manifest for repo:tag from the named registry.
+func newImageSource(ref string) (*registryImageSource, error) {
+	repo, tag, err := parseDockerRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := registry.NewClient(repo.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registryImageSource{client: client, repo: repo.Path, tag: tag}, nil
+}
+
+// LoadExportFromRegistry pulls the manifest and every layer blob named in
+// it from src, materializes them as a Docker v1 export tar under tempdir -
+// the same on-disk shape LoadExport already knows how to read - and loads
+// that the ordinary way. This lets Squash treat a docker://... input
+// exactly like a local tar input once the pull is done.
+func LoadExportFromRegistry(conf *configuration.Config, src ImageSource, tempdir string) (*Export, error) {
+	manifest, err := src.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	tarPath := filepath.Join(tempdir, "registry-pull.tar")
+	if err := writeV1TarFromManifest(tarPath, src, manifest); err != nil {
+		return nil, err
+	}
+
+	return LoadExport(conf, tarPath, tempdir)
+}
+
+// writeV1TarFromManifest streams every layer blob named in manifest into a
+// Docker v1 export tar at tarPath: one directory per layer (VERSION, json,
+// layer.tar), chained parent->child in manifest order, using each layer's
+// bare digest as its v1 layer ID. Gzip-compressed blobs (schema2 and OCI
+// both store layers as tar+gzip) are decompressed on the fly, since v1
+// layer.tar entries are always uncompressed.
+func writeV1TarFromManifest(tarPath string, src ImageSource, manifest *registry.Manifest) error {
+	out, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	defer tw.Close()
+
+	parent := ""
+	for _, desc := range manifest.Layers {
+		id := strings.TrimPrefix(desc.Digest, "sha256:")
+
+		layerTar, err := uncompressedLayerTar(src, desc)
+		if err != nil {
+			return err
+		}
+
+		layerJSON, err := json.Marshal(struct {
+			ID     string `json:"id"`
+			Parent string `json:"parent,omitempty"`
+		}{ID: id, Parent: parent})
+		if err != nil {
+			return err
+		}
+
+		if err := writeTarBytes(tw, id+"/VERSION", []byte("1.0")); err != nil {
+			return err
+		}
+		if err := writeTarBytes(tw, id+"/json", layerJSON); err != nil {
+			return err
+		}
+		if err := writeTarBytes(tw, id+"/layer.tar", layerTar); err != nil {
+			return err
+		}
+
+		parent = id
+	}
+
+	return nil
+}
+
+// uncompressedLayerTar fetches desc's blob from src and, if it's gzipped,
+// decompresses it into memory so it can be written out as a plain v1
+// layer.tar entry.
+func uncompressedLayerTar(src ImageSource, desc registry.Descriptor) ([]byte, error) {
+	blob, err := src.Blob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	if !strings.HasSuffix(desc.MediaType, "+gzip") {
+		return ioutil.ReadAll(blob)
+	}
+
+	gz, err := gzip.NewReader(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return ioutil.ReadAll(gz)
+}
+
+// writeTarBytes writes a single flat-file tar entry named name containing b.
+func writeTarBytes(tw *tar.Writer, name string, b []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(b)), Mode: 0644}); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// registryImageSource pulls a manifest and its layer blobs (schema2 or OCI)
+// straight from a registry, without ever touching a Docker daemon.
+type registryImageSource struct {
+	client *registry.Client
+	repo   string
+	tag    string
+}
+
+func (s *registryImageSource) Manifest() (*registry.Manifest, error) {
+	return s.client.GetManifest(s.repo, s.tag)
+}
+
+func (s *registryImageSource) Blob(digest string) (io.ReadCloser, error) {
+	return s.client.GetBlob(s.repo, digest)
+}
+
+// registryImageDestination pushes a squashed image's manifest, config and
+// layer blobs back to a registry, mirroring the pull-squash-push flow of
+// moby's v2Puller/v2Pusher.
+type registryImageDestination struct {
+	client *registry.Client
+	repo   string
+	tag    string
+}
+
+func newImageDestination(ref string) (*registryImageDestination, error) {
+	repo, tag, err := parseDockerRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := registry.NewClient(repo.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registryImageDestination{client: client, repo: repo.Path, tag: tag}, nil
+}
+
+func (d *registryImageDestination) PutBlob(digest string, size int64, r io.Reader) error {
+	return d.client.PutBlob(d.repo, digest, size, r)
+}
+
+func (d *registryImageDestination) PutManifest(m *registry.Manifest) error {
+	return d.client.PutManifest(d.repo, d.tag, m)
+}
+
+// PushToRegistry uploads layers (the full preserved chain from the image
+// root down to the freshly squashed layer, in parent->child order) to dst
+// as a single multi-layer image: each layer's gzipped blob, a config
+// listing all of their diffIDs, and finally the manifest tying them
+// together — the push half of the pull-squash-push flow docker://...
+// input/output enables.
+//
+// A partial squash (parent != "") leaves everything below parent
+// untouched, so those layers' existing blobs have to ship too; pushing
+// only the new squashed layer would produce an image a daemon can't
+// actually run.
+func PushToRegistry(layers []*Layer, dst ImageDestination) error {
+	blobDir, err := ioutil.TempDir("", "docker-squash-push")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(blobDir)
+
+	diffIDs := make([]string, 0, len(layers))
+	layerDescriptors := make([]registry.Descriptor, 0, len(layers))
+	for _, layer := range layers {
+		diffID, digest, size, err := writeGzippedLayerBlob(blobDir, layer.LayerTarPath())
+		if err != nil {
+			return err
+		}
+
+		blob, err := os.Open(filepath.Join(blobDir, digest))
+		if err != nil {
+			return err
+		}
+		err = dst.PutBlob(digest, size, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+
+		diffIDs = append(diffIDs, "sha256:"+diffID)
+		layerDescriptors = append(layerDescriptors, registry.Descriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    "sha256:" + digest,
+			Size:      size,
+		})
+	}
+
+	config := ociImageConfig{
+		Created: time.Now().UTC(),
+		RootFS:  ociRootFS{Type: "layers", DiffIDs: diffIDs},
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configSum := sha256.Sum256(configBytes)
+	configDigest := hex.EncodeToString(configSum[:])
+	if err := dst.PutBlob(configDigest, int64(len(configBytes)), bytes.NewReader(configBytes)); err != nil {
+		return err
+	}
+
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: registry.Descriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    "sha256:" + configDigest,
+			Size:      int64(len(configBytes)),
+		},
+		Layers: layerDescriptors,
+	}
+
+	return dst.PutManifest(manifest)
+}
+
+// dockerRepo is a parsed "registry.host/path/to/repo" reference.
+type dockerRepo struct {
+	Host string
+	Path string
+}
+
+// parseDockerRef splits a docker://host/repo:tag reference into its repo
+// and tag parts, defaulting the tag to "latest" like the local tar path
+// does elsewhere in Squash.
+func parseDockerRef(ref string) (dockerRepo, string, error) {
+	trimmed := strings.TrimPrefix(ref, dockerRefPrefix)
+	if trimmed == "" {
+		return dockerRepo{}, "", fmt.Errorf("squash: empty registry reference %q", ref)
+	}
+
+	name := trimmed
+	tag := "latest"
+	if idx := strings.LastIndex(trimmed, ":"); idx > strings.LastIndex(trimmed, "/") {
+		name = trimmed[:idx]
+		tag = trimmed[idx+1:]
+	}
+
+	slash := strings.Index(name, "/")
+	if slash < 0 {
+		return dockerRepo{}, "", fmt.Errorf("squash: registry reference %q is missing a repository path", ref)
+	}
+
+	return dockerRepo{Host: name[:slash], Path: name[slash+1:]}, tag, nil
+}
@@ -0,0 +1,49 @@
+package squash
+
+import "testing"
+
+func TestParseDockerRef(t *testing.T) {
+	tests := []struct {
+		ref      string
+		wantHost string
+		wantPath string
+		wantTag  string
+		wantErr  bool
+	}{
+		{ref: "docker://registry.example.com/foo/bar:latest", wantHost: "registry.example.com", wantPath: "foo/bar", wantTag: "latest"},
+		{ref: "docker://registry.example.com/foo/bar", wantHost: "registry.example.com", wantPath: "foo/bar", wantTag: "latest"},
+		{ref: "docker://registry.example.com:5000/foo/bar:v1", wantHost: "registry.example.com:5000", wantPath: "foo/bar", wantTag: "v1"},
+		{ref: "docker://", wantErr: true},
+		{ref: "docker://justahost", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		repo, tag, err := parseDockerRef(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDockerRef(%q): expected error, got nil", tt.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDockerRef(%q): unexpected error: %v", tt.ref, err)
+			continue
+		}
+		if repo.Host != tt.wantHost || repo.Path != tt.wantPath || tag != tt.wantTag {
+			t.Errorf("parseDockerRef(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tt.ref, repo.Host, repo.Path, tag, tt.wantHost, tt.wantPath, tt.wantTag)
+		}
+	}
+}
+
+func TestIsRegistryRef(t *testing.T) {
+	if !isRegistryRef("docker://registry.example.com/foo:latest") {
+		t.Error("expected docker:// ref to be recognized as a registry ref")
+	}
+	if isRegistryRef("/tmp/export.tar") {
+		t.Error("expected a local path to not be a registry ref")
+	}
+	if isRegistryRef("") {
+		t.Error("expected empty output (stdout) to not be a registry ref")
+	}
+}
@@ -21,6 +21,63 @@ var (
 
 type Squasher struct {
 	conf *configuration.Config
+
+	// Concurrency bounds how many layers are extracted/tarred in parallel.
+	// Zero means defaultConcurrency.
+	Concurrency int
+
+	// Format selects the output layout: FormatDockerV1 (the default, a
+	// single legacy tarball) or FormatOCI (an OCI image layout directory).
+	Format string
+
+	// Progress receives Start/Update/Complete events for each long-running
+	// step. Defaults to NoopProgress.
+	Progress Progress
+
+	// CacheDir, if set, caches squashed layer tars keyed by the ChainID of
+	// the input layers that produced them, so a later Squash over the same
+	// layers can skip straight to copying the cached result.
+	CacheDir string
+
+	// NoCache disables the cache above even when CacheDir is set, forcing
+	// every layer range to be re-squashed.
+	NoCache bool
+}
+
+// cache returns the Squasher's LayerCache, or nil if caching is disabled.
+func (s *Squasher) cache() *LayerCache {
+	if s.NoCache || s.CacheDir == "" {
+		return nil
+	}
+	return NewLayerCache(s.CacheDir)
+}
+
+// rootParent is the special parent value meaning "start from the image
+// root", matching the `root` keyword previously accepted by `from`.
+const rootParent = "root"
+
+// layersToRoot returns the chain of layers from the image root down to and
+// including end, in parent->child order. Used wherever a full output
+// (OCI layout, registry push) needs every preserved layer below the squash
+// point, not just the newly squashed one.
+func layersToRoot(end *Layer) []*Layer {
+	var layers []*Layer
+	for l := end; l != nil; l = l.Parent() {
+		layers = append(layers, l)
+	}
+	for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+		layers[i], layers[j] = layers[j], layers[i]
+	}
+	return layers
+}
+
+// format returns the configured output format, defaulting to the legacy
+// Docker v1 tarball when Format is unset.
+func (s *Squasher) format() string {
+	if s.Format == "" {
+		return FormatDockerV1
+	}
+	return s.Format
 }
 
 func (s *Squasher) shutdown(tempdir string) {
@@ -33,8 +90,22 @@ func (s *Squasher) shutdown(tempdir string) {
 	}
 }
 
-func (s *Squasher) Squash(input string, output string, tag string) error {
-	var from string
+// Squash flattens the layers of the image found at input into a single new
+// layer and writes the result to output, optionally tagging it as tag.
+//
+// parent selects the squash point the way Docker's SquashImage(id, parent)
+// does: layers up to and including parent are preserved untouched, and
+// everything above parent is merged into one new layer chained onto it.
+// parent may also be the literal string "root" to mean the image's root
+// layer. An empty parent is handled the same as "root": the root layer is
+// kept as-is and everything above it is merged into one new layer chained
+// onto the root; the root layer itself is not merged, so the result is not
+// a single scratch-parented layer.
+//
+// This package is the library half of cxbuild's squash support; the `squash`
+// command's --parent flag (cxbuild/cmd, outside this package) just forwards
+// its value straight through to this parameter.
+func (s *Squasher) Squash(input string, output string, tag string, parent string) error {
 	keepTemp := false
 
 	tempdir, err := ioutil.TempDir("", "docker-squash")
@@ -57,9 +128,21 @@ func (s *Squasher) Squash(input string, output string, tag string) error {
 		go s.shutdown(tempdir)
 	}
 
-	export, err := LoadExport(s.conf, input, tempdir)
-	if err != nil {
-		return err
+	var export *Export
+	if isRegistryRef(input) {
+		src, err := newImageSource(input)
+		if err != nil {
+			return err
+		}
+		export, err = LoadExportFromRegistry(s.conf, src, tempdir)
+		if err != nil {
+			return err
+		}
+	} else {
+		export, err = LoadExport(s.conf, input, tempdir)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Export may have multiple branches with the same parent.
@@ -76,36 +159,42 @@ func (s *Squasher) Squash(input string, output string, tag string) error {
 
 	}
 
-	start := export.FirstSquash()
-	// Can't find a previously squashed layer, use first FROM
-	if start == nil {
-		start = export.FirstFrom()
-	}
-	// Can't find a FROM, default to root
-	if start == nil {
+	var start *Layer
+	switch parent {
+	case "":
+		// No base given: same as rootParent, the root layer is kept and
+		// everything above it is squashed onto it.
 		start = export.Root()
-	}
-
-	if from != "" {
-
-		if from == "root" {
-			start = export.Root()
-		} else {
-			start, err = export.GetById(from)
-			if err != nil {
-				return err
-			}
+	case rootParent:
+		start = export.Root()
+	default:
+		start, err = export.GetById(parent)
+		if err != nil {
+			return err
 		}
 	}
 
 	if start == nil {
-		return fmt.Errorf("no layer matching %s\n", from)
+		return fmt.Errorf("no layer matching %s\n", parent)
 	}
 
-	// extract each "layer.tar" to "layer" dir
-	err = export.ExtractLayers()
-	if err != nil {
-		return err
+	// Compute the cache key against the layer chain as it exists right now,
+	// before InsertLayer splices the new (as yet tar-less) entry into it -
+	// walking the post-insertion chain would hash newEntry's own,
+	// not-yet-written layer.tar.
+	cache := s.cache()
+	var chainID string
+	if cache != nil {
+		chainID, err = chainIDForRange(start, export.LastChild())
+		if err == errEmptyLayerRange {
+			// Nothing above the squash point to merge (parent is already
+			// the last layer): there's no meaningful ChainID to cache
+			// under, so just skip caching for this squash instead of
+			// aliasing it onto every other no-op squash via a blank key.
+			cache = nil
+		} else if err != nil {
+			return err
+		}
 	}
 
 	// insert a new layer after our squash point
@@ -135,24 +224,59 @@ func (s *Squasher) Squash(input string, output string, tag string) error {
 		e = export.ChildOf(e.LayerConfig.Id)
 	}
 
-	// squash all later layers into our new layer
-	err = export.SquashLayers(newEntry, newEntry)
-	if err != nil {
-		return err
-	}
+	tm := NewTransferManager(s.Concurrency)
 
-	s.conf.Logger.Debug("Tarring up squashed layer %s\n", newEntry.LayerConfig.Id[:12])
-	// create a layer.tar from our squashed layer
-	err = newEntry.TarLayer()
-	if err != nil {
-		return err
+	cacheHit := false
+	if cache != nil {
+		cacheHit, err = cache.CopyInto(chainID, newEntry.LayerTarPath())
+		if err != nil {
+			return err
+		}
 	}
 
-	s.conf.Logger.Debug("Removing extracted layers\n")
-	// remove our expanded "layer" dirs
-	err = export.RemoveExtractedLayers()
-	if err != nil {
-		return err
+	if cacheHit {
+		s.conf.Logger.Debug("Cache hit for chain %s, reusing squashed layer\n", chainID[0:12])
+	} else {
+		// extract each "layer.tar" to "layer" dir, in parallel across a
+		// bounded worker pool instead of walking the layers one at a time
+		s.progress().Start("extract", "Extracting layers", -1)
+		err = export.ExtractLayers(tm, s.progress(), signals)
+		if err != nil {
+			return err
+		}
+		s.progress().Complete("extract")
+
+		// squash all later layers into our new layer; extraction of each
+		// layer happens in parallel on tm, but the merge itself still
+		// consumes layers in parent->child order so the result is
+		// deterministic
+		s.progress().Start("squash", "Merging layers", -1)
+		err = export.SquashLayers(newEntry, newEntry, tm, s.progress(), signals)
+		if err != nil {
+			return err
+		}
+		s.progress().Complete("squash")
+
+		// create a layer.tar from our squashed layer
+		s.progress().Start(newEntry.LayerConfig.Id, "Tarring squashed layer", -1)
+		err = newEntry.TarLayer()
+		if err != nil {
+			return err
+		}
+		s.progress().Complete(newEntry.LayerConfig.Id)
+
+		s.conf.Logger.Debug("Removing extracted layers\n")
+		// remove our expanded "layer" dirs
+		err = export.RemoveExtractedLayers()
+		if err != nil {
+			return err
+		}
+
+		if cache != nil {
+			if err := cache.Put(chainID, newEntry.LayerTarPath()); err != nil {
+				return err
+			}
+		}
 	}
 
 	if tag != "" {
@@ -176,21 +300,42 @@ func (s *Squasher) Squash(input string, output string, tag string) error {
 		}
 	}
 
-	ow := os.Stdout
-	if output != "" {
-		var err error
-		ow, err = os.Create(output)
+	if isRegistryRef(output) {
+		dst, err := newImageDestination(output)
 		if err != nil {
 			return err
 		}
-		s.conf.Logger.Debug("Tarring new image to %s\n", output)
+		s.conf.Logger.Debug("Pushing squashed image to %s\n", output)
+		if err := PushToRegistry(layersToRoot(newEntry), dst); err != nil {
+			return err
+		}
+	} else if s.format() == FormatOCI {
+		if output == "" {
+			return errors.New("an output directory is required for OCI output")
+		}
+		s.conf.Logger.Debug("Writing OCI image layout to %s\n", output)
+		if err := writeOCILayout(output, layersToRoot(newEntry)); err != nil {
+			return err
+		}
 	} else {
-		s.conf.Logger.Debug("Tarring new image to STDOUT\n")
-	}
-	// bundle up the new image
-	err = export.TarLayers(ow)
-	if err != nil {
-		return err
+		ow := os.Stdout
+		if output != "" {
+			var err error
+			ow, err = os.Create(output)
+			if err != nil {
+				return err
+			}
+			s.conf.Logger.Debug("Tarring new image to %s\n", output)
+		} else {
+			s.conf.Logger.Debug("Tarring new image to STDOUT\n")
+		}
+		// bundle up the new image, tarring layers in parallel on tm
+		s.progress().Start("tar", "Tarring new image", -1)
+		err = export.TarLayers(ow, tm, s.progress(), signals)
+		if err != nil {
+			return err
+		}
+		s.progress().Complete("tar")
 	}
 
 	s.conf.Logger.Debug("Done. New image created.")
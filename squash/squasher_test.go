@@ -0,0 +1,27 @@
+package squash
+
+import "testing"
+
+func TestSquasherFormatDefaultsToDockerV1(t *testing.T) {
+	s := &Squasher{}
+	if got := s.format(); got != FormatDockerV1 {
+		t.Fatalf("format() = %q, want %q", got, FormatDockerV1)
+	}
+}
+
+func TestSquasherFormatHonorsOverride(t *testing.T) {
+	s := &Squasher{Format: FormatOCI}
+	if got := s.format(); got != FormatOCI {
+		t.Fatalf("format() = %q, want %q", got, FormatOCI)
+	}
+}
+
+// Squash's parent/format/cache dispatch branches (the `switch parent` block,
+// the registry/OCI/docker-v1 output selection, and the cache hit/miss path)
+// all operate on *Export and *Layer, which this trimmed tree doesn't define
+// - they're expected to live in the export package alongside LoadExport,
+// InsertLayer, SquashLayers and friends, none of which are present here
+// either. An integration test exercising Squash end-to-end needs that
+// package's real (or fake) implementation to construct fixtures against;
+// the format() coverage above, and cache()'s in cache_dispatch_test.go, are
+// everything that's exercisable in isolation from this file.
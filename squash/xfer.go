@@ -0,0 +1,140 @@
+package squash
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCancelled is returned by a transferJob that was aborted because the
+// Squasher's signal channel fired.
+var errCancelled = errors.New("squash: transfer cancelled")
+
+// defaultConcurrency is used when a Squasher is not configured with an
+// explicit Concurrency.
+const defaultConcurrency = 5
+
+// maxRetries bounds the exponential backoff retries a single transfer job
+// gets before its failure is reported to the caller.
+const maxRetries = 3
+
+// transferJob is one unit of work handed to a TransferManager, e.g.
+// extracting a single layer.tar or re-tarring a squashed layer.
+type transferJob struct {
+	// ID identifies the job for deduplication, e.g. a layer ID. Two jobs
+	// submitted in the same Run with the same ID only execute once; the
+	// second gets the first's result.
+	ID string
+	Do func(cancel <-chan struct{}) error
+}
+
+// TransferManager runs transferJobs through a bounded worker pool, modeled
+// on moby's xfer download/upload manager: parallel execution, per-ID
+// deduplication, retry with exponential backoff, and clean cancellation.
+type TransferManager struct {
+	concurrency int
+}
+
+// NewTransferManager returns a TransferManager that runs at most concurrency
+// jobs at once. A concurrency <= 0 falls back to defaultConcurrency.
+func NewTransferManager(concurrency int) *TransferManager {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	return &TransferManager{concurrency: concurrency}
+}
+
+// Run executes jobs across the worker pool. It returns nil if every job
+// succeeded; otherwise it returns one error per job (nil for the jobs that
+// succeeded). Results are written back in the same order the jobs
+// were submitted in, regardless of completion order, so a caller that needs
+// to consume them in parent->child order (e.g. merging squashed layers) can
+// simply range over the returned slice.
+//
+// If cancel is closed, workers that haven't started yet are skipped and
+// in-flight jobs are given a chance to abort via the cancel channel passed
+// to transferJob.Do.
+func (tm *TransferManager) Run(jobs []transferJob, cancel <-chan struct{}) []error {
+	errs := make([]error, len(jobs))
+	seen := make(map[string]int, len(jobs))
+	// firstDone[i] is closed once errs[i] is ready, but only allocated for
+	// the first occurrence of each ID; duplicates wait on their original's
+	// own channel instead of a single channel shared with the outer wait,
+	// which is what let a duplicate's receive steal another job's signal.
+	firstDone := make([]chan struct{}, len(jobs))
+
+	sem := make(chan struct{}, tm.concurrency)
+	var wg sync.WaitGroup
+
+	for i, job := range jobs {
+		if first, ok := seen[job.ID]; ok {
+			wg.Add(1)
+			go func(i, first int) {
+				defer wg.Done()
+				<-firstDone[first]
+				errs[i] = errs[first]
+			}(i, first)
+			continue
+		}
+		seen[job.ID] = i
+		firstDone[i] = make(chan struct{})
+
+		wg.Add(1)
+		go func(i int, job transferJob, done chan struct{}) {
+			defer wg.Done()
+			defer close(done)
+
+			select {
+			case sem <- struct{}{}:
+			case <-cancel:
+				errs[i] = errCancelled
+				return
+			}
+			defer func() { <-sem }()
+
+			errs[i] = runWithBackoff(job, cancel)
+		}(i, job, firstDone[i])
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// runWithBackoff retries job.Do with exponential backoff, bailing out early
+// if cancel is closed.
+func runWithBackoff(job transferJob, cancel <-chan struct{}) error {
+	var err error
+	backoff := 100 * time.Millisecond
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		select {
+		case <-cancel:
+			return errCancelled
+		default:
+		}
+
+		err = job.Do(cancel)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == maxRetries {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-cancel:
+			return errCancelled
+		}
+		backoff *= 2
+	}
+
+	return err
+}
@@ -0,0 +1,88 @@
+package squash
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransferManagerDedupesByID(t *testing.T) {
+	var runs int32
+
+	jobs := []transferJob{
+		{ID: "layer-a", Do: func(cancel <-chan struct{}) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}},
+		{ID: "layer-a", Do: func(cancel <-chan struct{}) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}},
+		{ID: "layer-b", Do: func(cancel <-chan struct{}) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}},
+	}
+
+	tm := NewTransferManager(4)
+	if errs := tm.Run(jobs, nil); errs != nil {
+		t.Fatalf("Run returned errors: %v", errs)
+	}
+
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Fatalf("expected 2 unique jobs to run, got %d", got)
+	}
+}
+
+func TestTransferManagerDuplicateGetsOriginalsResult(t *testing.T) {
+	wantErr := errors.New("boom")
+
+	jobs := []transferJob{
+		{ID: "layer-a", Do: func(cancel <-chan struct{}) error { return wantErr }},
+		{ID: "layer-a", Do: func(cancel <-chan struct{}) error {
+			t.Fatal("duplicate job should not run Do")
+			return nil
+		}},
+	}
+
+	tm := NewTransferManager(2)
+	errs := tm.Run(jobs, nil)
+	if errs == nil {
+		t.Fatal("expected errors, got nil")
+	}
+	if errs[0] == nil || errs[0].Error() != wantErr.Error() {
+		t.Fatalf("errs[0] = %v, want %v", errs[0], wantErr)
+	}
+	if errs[1] == nil || errs[1].Error() != wantErr.Error() {
+		t.Fatalf("errs[1] = %v, want a copy of errs[0] (%v)", errs[1], wantErr)
+	}
+}
+
+// TestTransferManagerNoHangWithDuplicates reproduces the scenario from code
+// review: a duplicate ID racing the main wait loop for the same completion
+// signal. Run many iterations with concurrency > 1 so a regression of the
+// shared-channel bug would hang instead of returning.
+func TestTransferManagerNoHangWithDuplicates(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		jobs := []transferJob{
+			{ID: "dup", Do: func(cancel <-chan struct{}) error { return nil }},
+			{ID: "dup", Do: func(cancel <-chan struct{}) error { return nil }},
+			{ID: "other", Do: func(cancel <-chan struct{}) error { return nil }},
+		}
+
+		done := make(chan []error, 1)
+		go func() {
+			done <- NewTransferManager(4).Run(jobs, nil)
+		}()
+
+		select {
+		case errs := <-done:
+			if errs != nil {
+				t.Fatalf("iteration %d: unexpected errors %v", i, errs)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("iteration %d: Run did not return in time", i)
+		}
+	}
+}